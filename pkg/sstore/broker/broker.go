@@ -0,0 +1,59 @@
+// Package broker is the transport layer for fanning sstore mutations out to
+// other sh2-server processes, so an update applied on one node shows up in
+// the websocket clients connected to every other node.  It only knows about
+// bytes on a channel; sstore owns the ModelUpdate payload shape.
+package broker
+
+import (
+	"context"
+)
+
+// Channel is the pub/sub channel all sh2-server nodes publish updates to and
+// subscribe on.
+const Channel = "sh2:updates"
+
+// PubSub publishes/subscribes opaque payloads on Channel.  Implementations
+// back this with an external message bus (redis) or, by default, do nothing.
+type PubSub interface {
+	Publish(ctx context.Context, payload []byte) error
+	Subscribe(ctx context.Context) (<-chan []byte, error)
+	Close() error
+}
+
+// globalPubSub is the active transport for this process.  It defaults to a
+// NoOpPubSub so Publish is always safe to call even with no bus configured.
+var globalPubSub PubSub = &NoOpPubSub{}
+
+// Set installs ps as the process-wide PubSub.
+func Set(ps PubSub) {
+	if ps == nil {
+		ps = &NoOpPubSub{}
+	}
+	globalPubSub = ps
+}
+
+// Get returns the active PubSub.
+func Get() PubSub {
+	return globalPubSub
+}
+
+// NoOpPubSub is the default transport used when no message bus is
+// configured.  Publish is a no-op and Subscribe never yields anything.
+type NoOpPubSub struct{}
+
+func (ps *NoOpPubSub) Publish(ctx context.Context, payload []byte) error {
+	return nil
+}
+
+func (ps *NoOpPubSub) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	ch := make(chan []byte)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func (ps *NoOpPubSub) Close() error {
+	return nil
+}
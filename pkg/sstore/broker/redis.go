@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisPubSub publishes/subscribes over a redis pub/sub channel, letting
+// multiple sh2-server processes share one logical set of sessions.
+type RedisPubSub struct {
+	client *redis.Client
+}
+
+// NewRedisPubSub connects to the redis server at uri, e.g.
+// redis://localhost:6379.
+func NewRedisPubSub(ctx context.Context, uri string) (*RedisPubSub, error) {
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis uri[%s]: %w", uri, err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("connecting to redis[%s]: %w", uri, err)
+	}
+	return &RedisPubSub{client: client}, nil
+}
+
+func (ps *RedisPubSub) Publish(ctx context.Context, payload []byte) error {
+	if err := ps.client.Publish(ctx, Channel, payload).Err(); err != nil {
+		return fmt.Errorf("publishing to redis: %w", err)
+	}
+	return nil
+}
+
+func (ps *RedisPubSub) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	sub := ps.client.Subscribe(ctx, Channel)
+	rtn := make(chan []byte)
+	go func() {
+		defer close(rtn)
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case rtn <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return rtn, nil
+}
+
+func (ps *RedisPubSub) Close() error {
+	return ps.client.Close()
+}
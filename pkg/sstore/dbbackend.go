@@ -0,0 +1,181 @@
+package sstore
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DBUriEnvName is the environment variable used to point sh2-server at a
+// shared server-side database instead of the default per-user sqlite file.
+// e.g. WAVETERM_DB=mysql://user@host:3306/wave
+const DBUriEnvName = "WAVETERM_DB"
+
+// Backend abstracts the sql driver and dialect quirks needed to run sstore
+// against something other than the default local sqlite3 file.  The rest of
+// the package talks to the active Backend rather than a specific driver.
+//
+// Every query in this package is written with bare "?" placeholders, which
+// sqlite3 and mysql both accept natively.  Postgres (lib/pq) requires
+// "$1, $2, ..." placeholders instead, and nothing here rewrites queries per
+// dialect yet (Backend.Dialect() exists for exactly that, once it's wired
+// up via db.Rebind), so a postgres backend is intentionally not offered --
+// supporting the scheme would connect and migrate fine, then fail on the
+// first real query.
+type Backend interface {
+	// Open returns a live connection pool for this backend.
+	Open() (*sqlx.DB, error)
+
+	// Migrate runs (or no-ops) schema migrations against db.
+	Migrate(db *sqlx.DB) error
+
+	// Dialect is the name of the underlying sql driver ("sqlite3", "mysql").
+	Dialect() string
+
+	// JSONExtract wraps a column expression in this dialect's JSON field-extraction function.
+	JSONExtract(col string, path string) string
+
+	// ReturningClause returns the dialect-specific " RETURNING ..." suffix for
+	// an INSERT, or "" when the dialect has no such clause (mysql).
+	ReturningClause(cols ...string) string
+}
+
+type sqliteBackend struct {
+	dbFileName string
+}
+
+func (b *sqliteBackend) Open() (*sqlx.DB, error) {
+	connStr := fmt.Sprintf("file:%s?cache=shared&mode=rwc&_journal_mode=WAL&_busy_timeout=5000", b.dbFileName)
+	db, err := sqlx.Open("sqlite3", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("opening db[%s]: %w", b.dbFileName, err)
+	}
+	return db, nil
+}
+
+func (b *sqliteBackend) Migrate(db *sqlx.DB) error {
+	if err := MigrateDB(db, b.Dialect()); err != nil {
+		return err
+	}
+	// FTS5 is sqlite-specific, so the history search index is only created
+	// (and only kept in sync) for this backend.
+	if _, err := db.Exec(historyFtsSchema); err != nil {
+		return fmt.Errorf("creating history_fts: %w", err)
+	}
+	return nil
+}
+
+func (b *sqliteBackend) Dialect() string {
+	return "sqlite3"
+}
+
+func (b *sqliteBackend) JSONExtract(col string, path string) string {
+	return fmt.Sprintf("json_extract(%s, '%s')", col, path)
+}
+
+func (b *sqliteBackend) ReturningClause(cols ...string) string {
+	return " RETURNING " + strings.Join(cols, ", ")
+}
+
+type mysqlBackend struct {
+	dsn string
+}
+
+func (b *mysqlBackend) Open() (*sqlx.DB, error) {
+	db, err := sqlx.Open("mysql", b.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening mysql db: %w", err)
+	}
+	return db, nil
+}
+
+func (b *mysqlBackend) Migrate(db *sqlx.DB) error {
+	return MigrateDB(db, b.Dialect())
+}
+
+func (b *mysqlBackend) Dialect() string {
+	return "mysql"
+}
+
+func (b *mysqlBackend) JSONExtract(col string, path string) string {
+	return fmt.Sprintf("JSON_EXTRACT(%s, '%s')", col, path)
+}
+
+func (b *mysqlBackend) ReturningClause(cols ...string) string {
+	// mysql has no RETURNING clause; callers fall back to a follow-up SELECT.
+	return ""
+}
+
+// BackendFromUri builds a Backend from a connection URI.  An empty uri means
+// "use the default per-user sqlite3 file at dbFileName".  Recognized schemes
+// are sqlite/sqlite3 (a bare file path) and mysql.  postgres/postgresql is
+// deliberately not recognized -- see the postgres note on the Backend type.
+func BackendFromUri(uri string, dbFileName string) (Backend, error) {
+	if uri == "" {
+		return &sqliteBackend{dbFileName: dbFileName}, nil
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", DBUriEnvName, err)
+	}
+	switch parsed.Scheme {
+	case "", "sqlite", "sqlite3":
+		return &sqliteBackend{dbFileName: sqlitePathFromUri(parsed, dbFileName)}, nil
+	case "mysql":
+		dsn, err := mysqlDsnFromUri(parsed)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", DBUriEnvName, err)
+		}
+		return &mysqlBackend{dsn: dsn}, nil
+	case "postgres", "postgresql":
+		return nil, fmt.Errorf("%s scheme %q is not supported yet (queries aren't rewritten for postgres bind syntax)", DBUriEnvName, parsed.Scheme)
+	default:
+		return nil, fmt.Errorf("unrecognized %s scheme %q", DBUriEnvName, parsed.Scheme)
+	}
+}
+
+// sqlitePathFromUri extracts the filesystem path to open from a parsed
+// sqlite/sqlite3 uri ("sqlite:/custom/path.db", "sqlite://host/path.db", or
+// a bare path with no scheme at all), falling back to dbFileName when the
+// uri carries no path of its own.
+func sqlitePathFromUri(parsed *url.URL, dbFileName string) string {
+	switch {
+	case parsed.Opaque != "":
+		return parsed.Opaque
+	case parsed.Host != "" && parsed.Path != "":
+		return parsed.Host + parsed.Path
+	case parsed.Path != "":
+		return parsed.Path
+	default:
+		return dbFileName
+	}
+}
+
+// mysqlDsnFromUri converts a mysql:// uri into the
+// user[:pass]@tcp(host:port)/dbname go-sql-driver/mysql DSN form; the driver
+// does not understand bare URLs, so the scheme can't just be trimmed off.
+func mysqlDsnFromUri(parsed *url.URL) (string, error) {
+	host := parsed.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("mysql uri missing host")
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "3306"
+	}
+	var userInfo string
+	if parsed.User != nil {
+		userInfo = parsed.User.String() + "@"
+	}
+	dbName := strings.TrimPrefix(parsed.Path, "/")
+	dsn := fmt.Sprintf("%stcp(%s:%s)/%s", userInfo, host, port, dbName)
+	if parsed.RawQuery != "" {
+		dsn += "?" + parsed.RawQuery
+	}
+	return dsn, nil
+}
@@ -0,0 +1,84 @@
+package sstore
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ErrTimeout is returned by WithDeadline when the deadline elapses while
+// retrying a busy/locked database, as distinct from context.DeadlineExceeded
+// so callers can tell "the DB is contended" from "the caller cancelled".
+var ErrTimeout = errors.New("sstore: deadline exceeded waiting on database")
+
+const deadlineRetryBaseDelay = 10 * time.Millisecond
+const deadlineRetryMaxDelay = 250 * time.Millisecond
+
+// DefaultDBTimeout bounds how long a single WithTxDeadline call will retry a
+// busy/locked database before giving up with ErrTimeout.
+const DefaultDBTimeout = 5 * time.Second
+
+// WithDeadline runs fn against a bounded-time copy of ctx, retrying with
+// jittered backoff when fn fails with SQLITE_BUSY/SQLITE_LOCKED, up until d
+// elapses.  The total time is bounded by a context.WithTimeout copy of ctx
+// (a cancel channel closed by a timer), with a per-retry timer for the
+// backoff, rather than relying solely on the sqlite3 driver's _busy_timeout, since
+// that only bounds a single statement, not a whole WithTx callback.
+func WithDeadline(ctx context.Context, d time.Duration, fn func(ctx context.Context) error) error {
+	deadlineCtx, cancelFn := context.WithTimeout(ctx, d)
+	defer cancelFn()
+	start := time.Now()
+	delay := deadlineRetryBaseDelay
+	for {
+		err := fn(deadlineCtx)
+		if err == nil {
+			return nil
+		}
+		if !isBusyErr(err) {
+			return err
+		}
+		remaining := d - time.Since(start)
+		if remaining <= 0 {
+			return ErrTimeout
+		}
+		sleepFor := jitter(delay)
+		if sleepFor > remaining {
+			sleepFor = remaining
+		}
+		timer := time.NewTimer(sleepFor)
+		select {
+		case <-deadlineCtx.Done():
+			timer.Stop()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrTimeout
+		case <-timer.C:
+		}
+		delay *= 2
+		if delay > deadlineRetryMaxDelay {
+			delay = deadlineRetryMaxDelay
+		}
+	}
+}
+
+// WithTxDeadline is the WithTx equivalent of WithDeadline -- all call sites
+// that used to call WithTx directly should go through this so a wedged
+// writer can't hold a websocket handler open indefinitely.
+func WithTxDeadline(ctx context.Context, d time.Duration, fn func(tx *TxWrap) error) error {
+	return WithDeadline(ctx, d, func(dctx context.Context) error {
+		return WithTx(dctx, fn)
+	})
+}
+
+func isBusyErr(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "SQLITE_BUSY") || strings.Contains(msg, "SQLITE_LOCKED") ||
+		strings.Contains(msg, "database is locked")
+}
+
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
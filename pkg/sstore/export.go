@@ -0,0 +1,433 @@
+package sstore
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// exportManifestFile, exportSigFile, and exportPubKeyFile are the entries of
+// an exported session tarball: the serialized data, an ECDSA signature over
+// its sha256, and the exporter's public key to verify that signature on
+// import (without requiring the importer to already know the exporter).
+const exportManifestFile = "manifest.json"
+const exportSigFile = "manifest.sig"
+const exportPubKeyFile = "exporter.pub"
+
+// exportManifest is everything needed to reconstitute a session: the
+// SessionType and the rows that hang off it.  RunOut packet streams travel
+// inside CmdType.RunOut as already-collected []packet.PacketType, same as
+// they're held in memory.  This in-memory shape always holds unsealed
+// CmdStr/RunOut -- see exportWireManifest for the sealed form that actually
+// gets written to the tarball.
+type exportManifest struct {
+	Session *SessionType      `json:"session"`
+	Screens []*ScreenType     `json:"screens"`
+	Windows []*WindowType     `json:"windows"`
+	Lines   []*LineType       `json:"lines"`
+	Cmds    []*CmdType        `json:"cmds"`
+	Remotes []*RemoteInstance `json:"remotes"`
+}
+
+// exportWireWindow is the subset of WindowType that belongs in a tarball:
+// the window's own scalar fields, without its embedded Lines/Cmds/Remotes
+// (those already travel as exportWireManifest's own top-level lists, and
+// leaving them on the window would re-embed cmd output a second time,
+// unsealed).
+type exportWireWindow struct {
+	SessionId   string              `json:"sessionid"`
+	WindowId    string              `json:"windowid"`
+	CurRemote   string              `json:"curremote"`
+	WinOpts     WindowOptsType      `json:"winopts"`
+	OwnerUserId string              `json:"owneruserid"`
+	ShareMode   string              `json:"sharemode"`
+	ShareOpts   WindowShareOptsType `json:"shareopts"`
+}
+
+// exportWireManifest is the on-disk form of exportManifest: cmds are held as
+// their sealed ToMap() representation (same as cmd rows at rest in the cmd
+// table) rather than plaintext CmdType, so a session handed to a coworker
+// doesn't reverse the encryption-at-rest guarantee on its way out.
+type exportWireManifest struct {
+	Session *SessionType             `json:"session"`
+	Screens []*ScreenType            `json:"screens"`
+	Windows []*exportWireWindow      `json:"windows"`
+	Lines   []*LineType              `json:"lines"`
+	Cmds    []map[string]interface{} `json:"cmds"`
+	Remotes []*RemoteInstance        `json:"remotes"`
+}
+
+func toWireManifest(manifest *exportManifest) *exportWireManifest {
+	wire := &exportWireManifest{
+		Session: manifest.Session,
+		Screens: manifest.Screens,
+		Lines:   manifest.Lines,
+		Remotes: manifest.Remotes,
+	}
+	for _, window := range manifest.Windows {
+		wire.Windows = append(wire.Windows, &exportWireWindow{
+			SessionId:   window.SessionId,
+			WindowId:    window.WindowId,
+			CurRemote:   window.CurRemote,
+			WinOpts:     window.WinOpts,
+			OwnerUserId: window.OwnerUserId,
+			ShareMode:   window.ShareMode,
+			ShareOpts:   window.ShareOpts,
+		})
+	}
+	for _, cmd := range manifest.Cmds {
+		wire.Cmds = append(wire.Cmds, cmd.ToMap())
+	}
+	return wire
+}
+
+func fromWireManifest(wire *exportWireManifest) *exportManifest {
+	manifest := &exportManifest{
+		Session: wire.Session,
+		Screens: wire.Screens,
+		Lines:   wire.Lines,
+		Remotes: wire.Remotes,
+	}
+	for _, window := range wire.Windows {
+		manifest.Windows = append(manifest.Windows, &WindowType{
+			SessionId:   window.SessionId,
+			WindowId:    window.WindowId,
+			CurRemote:   window.CurRemote,
+			WinOpts:     window.WinOpts,
+			OwnerUserId: window.OwnerUserId,
+			ShareMode:   window.ShareMode,
+			ShareOpts:   window.ShareOpts,
+		})
+	}
+	for _, cmdMap := range wire.Cmds {
+		manifest.Cmds = append(manifest.Cmds, CmdFromMap(cmdMap))
+	}
+	return manifest
+}
+
+// ExportSession serializes a session and everything that hangs off it
+// (screens, windows, lines, cmds, and referenced remote instances) into a
+// tarball written to w, signed with the local user's private key.  The
+// matching public key travels in the same tarball, so ImportSession's check
+// catches a manifest altered without re-signing; it does not catch an
+// archive re-signed with a different key.
+func ExportSession(ctx context.Context, sessionId string, w io.Writer) error {
+	manifest, err := buildExportManifest(ctx, sessionId)
+	if err != nil {
+		return fmt.Errorf("building export manifest for session[%s]: %w", sessionId, err)
+	}
+	manifestBytes, err := json.Marshal(toWireManifest(manifest))
+	if err != nil {
+		return fmt.Errorf("marshaling export manifest: %w", err)
+	}
+	userData, err := EnsureUserData(ctx)
+	if err != nil {
+		return fmt.Errorf("loading user keypair: %w", err)
+	}
+	sig, err := signManifest(userData.UserPrivateKey, manifestBytes)
+	if err != nil {
+		return fmt.Errorf("signing export manifest: %w", err)
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(userData.UserPublicKey)
+	if err != nil {
+		return fmt.Errorf("marshaling exporter public key: %w", err)
+	}
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	if err := writeTarFile(tw, exportManifestFile, manifestBytes); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, exportSigFile, sig); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, exportPubKeyFile, pubKeyBytes); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("closing export tarball: %w", err)
+	}
+	if err := gzw.Close(); err != nil {
+		return fmt.Errorf("closing export gzip stream: %w", err)
+	}
+	return nil
+}
+
+// ImportSession reads a tarball produced by ExportSession, verifies its
+// signature against the embedded exporter public key, mints new ids for the
+// session and everything under it, rewrites ownership to the local user, and
+// downgrades the share mode to ShareModeLocal (shared sessions are not
+// importable as shared -- see ShareModeShared).
+func ImportSession(ctx context.Context, r io.Reader) (*SessionType, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening import gzip stream: %w", err)
+	}
+	defer gzr.Close()
+	files, err := readTarFiles(gzr)
+	if err != nil {
+		return nil, err
+	}
+	manifestBytes, ok := files[exportManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("import archive missing %s", exportManifestFile)
+	}
+	sig, ok := files[exportSigFile]
+	if !ok {
+		return nil, fmt.Errorf("import archive missing %s", exportSigFile)
+	}
+	pubKeyBytes, ok := files[exportPubKeyFile]
+	if !ok {
+		return nil, fmt.Errorf("import archive missing %s", exportPubKeyFile)
+	}
+	pubKeyAny, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing exporter public key: %w", err)
+	}
+	pubKey, ok := pubKeyAny.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("exporter public key has wrong type: %T", pubKeyAny)
+	}
+	if !verifyManifest(pubKey, manifestBytes, sig) {
+		return nil, fmt.Errorf("import archive failed signature verification")
+	}
+	var wireManifest exportWireManifest
+	if err := json.Unmarshal(manifestBytes, &wireManifest); err != nil {
+		return nil, fmt.Errorf("unmarshaling import manifest: %w", err)
+	}
+	manifest := fromWireManifest(&wireManifest)
+	userData, err := EnsureUserData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading local user: %w", err)
+	}
+	rewriteManifestIds(manifest, userData.UserId)
+	if err := insertImportedManifest(ctx, manifest); err != nil {
+		return nil, fmt.Errorf("inserting imported session: %w", err)
+	}
+	if err := PublishUpdate(ctx, ModelUpdate{Sessions: []*SessionType{manifest.Session}}); err != nil {
+		log.Printf("[db] error publishing update: %v\n", err)
+	}
+	return manifest.Session, nil
+}
+
+func buildExportManifest(ctx context.Context, sessionId string) (*exportManifest, error) {
+	session, err := GetSessionById(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("session[%s] not found", sessionId)
+	}
+	windows, err := GetWindowsForSession(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &exportManifest{
+		Session: session,
+		Screens: session.Screens,
+		Windows: windows,
+		Remotes: session.Remotes,
+	}
+	for _, window := range windows {
+		manifest.Lines = append(manifest.Lines, window.Lines...)
+		manifest.Cmds = append(manifest.Cmds, window.Cmds...)
+		manifest.Remotes = append(manifest.Remotes, window.Remotes...)
+	}
+	return manifest, nil
+}
+
+// rewriteManifestIds mints fresh ids for everything in manifest, remaps
+// every reference to an old id (including the screen/window layout join and
+// the session/screen Active*Id pointers), and points ownership at the
+// local, importing user.
+func rewriteManifestIds(manifest *exportManifest, localUserId string) {
+	oldToNewSessionId := map[string]string{manifest.Session.SessionId: uuid.New().String()}
+	manifest.Session.SessionId = oldToNewSessionId[manifest.Session.SessionId]
+	manifest.Session.OwnerUserId = localUserId
+	manifest.Session.ShareMode = ShareModeLocal
+	manifest.Session.AccessKey = ""
+
+	oldToNewScreenId := make(map[string]string)
+	for _, screen := range manifest.Screens {
+		newScreenId := uuid.New().String()
+		oldToNewScreenId[screen.ScreenId] = newScreenId
+		screen.SessionId = manifest.Session.SessionId
+		screen.ScreenId = newScreenId
+		screen.OwnerUserId = localUserId
+		screen.ShareMode = ShareModeLocal
+	}
+	if newScreenId, ok := oldToNewScreenId[manifest.Session.ActiveScreenId]; ok {
+		manifest.Session.ActiveScreenId = newScreenId
+	}
+	oldToNewWindowId := make(map[string]string)
+	for _, window := range manifest.Windows {
+		newWindowId := uuid.New().String()
+		oldToNewWindowId[window.WindowId] = newWindowId
+		window.SessionId = manifest.Session.SessionId
+		window.WindowId = newWindowId
+		window.OwnerUserId = localUserId
+		window.ShareMode = ShareModeLocal
+	}
+	for _, screen := range manifest.Screens {
+		if newWindowId, ok := oldToNewWindowId[screen.ActiveWindowId]; ok {
+			screen.ActiveWindowId = newWindowId
+		}
+		for _, screenWindow := range screen.Windows {
+			screenWindow.SessionId = manifest.Session.SessionId
+			screenWindow.ScreenId = screen.ScreenId
+			if newWindowId, ok := oldToNewWindowId[screenWindow.WindowId]; ok {
+				screenWindow.WindowId = newWindowId
+			}
+		}
+	}
+	oldToNewCmdId := make(map[string]string)
+	for _, cmd := range manifest.Cmds {
+		newCmdId := uuid.New().String()
+		oldToNewCmdId[cmd.CmdId] = newCmdId
+		cmd.SessionId = manifest.Session.SessionId
+		cmd.CmdId = newCmdId
+	}
+	for _, line := range manifest.Lines {
+		line.SessionId = manifest.Session.SessionId
+		line.WindowId = oldToNewWindowId[line.WindowId]
+		line.LineId = uuid.New().String()
+		line.UserId = localUserId
+		if newCmdId, ok := oldToNewCmdId[line.CmdId]; ok {
+			line.CmdId = newCmdId
+		}
+	}
+	for _, remote := range manifest.Remotes {
+		remote.SessionId = manifest.Session.SessionId
+		if newWindowId, ok := oldToNewWindowId[remote.WindowId]; ok {
+			remote.WindowId = newWindowId
+		}
+		remote.RIId = uuid.New().String()
+	}
+}
+
+// insertImportedManifest persists a manifest (with ids already rewritten by
+// rewriteManifestIds) as a brand new session.
+func insertImportedManifest(ctx context.Context, manifest *exportManifest) error {
+	return WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		s := manifest.Session
+		tx.ExecWrap(
+			`INSERT INTO session (sessionid, name, sessionidx, activescreenid, owneruserid, sharemode, accesskey, notifynum)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			s.SessionId, s.Name, s.SessionIdx, s.ActiveScreenId, s.OwnerUserId, s.ShareMode, s.AccessKey, s.NotifyNum)
+		for _, screen := range manifest.Screens {
+			tx.ExecWrap(
+				`INSERT INTO screen (sessionid, screenid, screenidx, name, activewindowid, screenopts, owneruserid, sharemode)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				screen.SessionId, screen.ScreenId, screen.ScreenIdx, screen.Name, screen.ActiveWindowId, screen.ScreenOpts, screen.OwnerUserId, screen.ShareMode)
+			for _, screenWindow := range screen.Windows {
+				tx.ExecWrap(
+					`INSERT INTO screen_window (sessionid, screenid, windowid, name, layout)
+					 VALUES (?, ?, ?, ?, ?)`,
+					screenWindow.SessionId, screenWindow.ScreenId, screenWindow.WindowId, screenWindow.Name, screenWindow.Layout)
+			}
+		}
+		for _, window := range manifest.Windows {
+			tx.ExecWrap(
+				`INSERT INTO window (sessionid, windowid, curremote, winopts, owneruserid, sharemode, shareopts)
+				 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				window.SessionId, window.WindowId, window.CurRemote, window.WinOpts, window.OwnerUserId, window.ShareMode, window.ShareOpts)
+		}
+		for _, line := range manifest.Lines {
+			tx.ExecWrap(
+				`INSERT INTO line (sessionid, windowid, lineid, ts, userid, linetype, text, cmdid)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				line.SessionId, line.WindowId, line.LineId, line.Ts, line.UserId, line.LineType, line.Text, line.CmdId)
+		}
+		for _, cmd := range manifest.Cmds {
+			cmdMap := cmd.ToMap()
+			tx.ExecWrap(
+				`INSERT INTO cmd (sessionid, cmdid, remoteid, cmdstr, remotestate, termopts, status, startpk, donepk, runout, usedrows)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				cmdMap["sessionid"], cmdMap["cmdid"], cmdMap["remoteid"], cmdMap["cmdstr"], cmdMap["remotestate"], cmdMap["termopts"],
+				cmdMap["status"], cmdMap["startpk"], cmdMap["donepk"], cmdMap["runout"], cmdMap["usedrows"])
+		}
+		for _, remote := range manifest.Remotes {
+			tx.ExecWrap(
+				`INSERT INTO remote_instance (riid, name, sessionid, windowid, remoteid, sessionscope, state)
+				 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				remote.RIId, remote.Name, remote.SessionId, remote.WindowId, remote.RemoteId, remote.SessionScope, remote.State)
+		}
+		return nil
+	})
+}
+
+func signManifest(privKey *ecdsa.PrivateKey, manifestBytes []byte) ([]byte, error) {
+	digest := sha256.Sum256(manifestBytes)
+	return ecdsa.SignASN1(rand.Reader, privKey, digest[:])
+}
+
+func verifyManifest(pubKey *ecdsa.PublicKey, manifestBytes []byte, sig []byte) bool {
+	digest := sha256.Sum256(manifestBytes)
+	return ecdsa.VerifyASN1(pubKey, digest[:], sig)
+}
+
+func writeTarFile(tw *tar.Writer, name string, contents []byte) error {
+	hdr := &tar.Header{Name: name, Size: int64(len(contents)), Mode: 0600}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing tar header for %s: %w", name, err)
+	}
+	if _, err := tw.Write(contents); err != nil {
+		return fmt.Errorf("writing tar contents for %s: %w", name, err)
+	}
+	return nil
+}
+
+// An import archive is, by design, handed over from another user, so
+// readTarFiles bounds both the number of entries and how much decompressed
+// data it will read -- without these a crafted or corrupted tarball (lying
+// headers included) could exhaust the importing process's memory.
+const maxImportEntries = 64
+const maxImportEntrySize = 64 * 1024 * 1024  // 64MB, generous for one session's cmd output
+const maxImportTotalSize = 256 * 1024 * 1024 // 256MB decompressed, across all entries
+
+func readTarFiles(r io.Reader) (map[string][]byte, error) {
+	tr := tar.NewReader(r)
+	rtn := make(map[string][]byte)
+	var totalSize int64
+	entryCount := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading import tarball: %w", err)
+		}
+		entryCount++
+		if entryCount > maxImportEntries {
+			return nil, fmt.Errorf("import tarball has too many entries (max %d)", maxImportEntries)
+		}
+		if hdr.Size > maxImportEntrySize {
+			return nil, fmt.Errorf("import tarball entry %s too large (max %d bytes)", hdr.Name, maxImportEntrySize)
+		}
+		totalSize += hdr.Size
+		if totalSize > maxImportTotalSize {
+			return nil, fmt.Errorf("import tarball exceeds max decompressed size (%d bytes)", maxImportTotalSize)
+		}
+		// tar headers are attacker-controlled, so cap the actual read too in
+		// case a header understates its entry's real size.
+		contents, err := io.ReadAll(io.LimitReader(tr, maxImportEntrySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("reading tar entry %s: %w", hdr.Name, err)
+		}
+		if int64(len(contents)) > maxImportEntrySize {
+			return nil, fmt.Errorf("import tarball entry %s too large (max %d bytes)", hdr.Name, maxImportEntrySize)
+		}
+		rtn[hdr.Name] = contents
+	}
+	return rtn, nil
+}
@@ -0,0 +1,134 @@
+package sstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+func TestRewriteManifestIdsMintsFreshCmdId(t *testing.T) {
+	manifest := &exportManifest{
+		Session: &SessionType{SessionId: "old-session"},
+		Windows: []*WindowType{
+			{SessionId: "old-session", WindowId: "old-window"},
+		},
+		Lines: []*LineType{
+			{SessionId: "old-session", WindowId: "old-window", CmdId: "old-cmd"},
+		},
+		Cmds: []*CmdType{
+			{SessionId: "old-session", CmdId: "old-cmd"},
+		},
+	}
+
+	rewriteManifestIds(manifest, "local-user")
+
+	newCmdId := manifest.Cmds[0].CmdId
+	if newCmdId == "" || newCmdId == "old-cmd" {
+		t.Errorf("cmd id not minted fresh: got %q", newCmdId)
+	}
+	if manifest.Cmds[0].SessionId != manifest.Session.SessionId {
+		t.Errorf("cmd sessionid = %q, want %q", manifest.Cmds[0].SessionId, manifest.Session.SessionId)
+	}
+	if manifest.Lines[0].CmdId != newCmdId {
+		t.Errorf("line cmdid = %q, want remapped %q", manifest.Lines[0].CmdId, newCmdId)
+	}
+	if manifest.Lines[0].WindowId != manifest.Windows[0].WindowId {
+		t.Errorf("line windowid = %q, want remapped %q", manifest.Lines[0].WindowId, manifest.Windows[0].WindowId)
+	}
+}
+
+func TestRewriteManifestIdsRemapsScreensAndActiveIds(t *testing.T) {
+	manifest := &exportManifest{
+		Session: &SessionType{SessionId: "old-session", ActiveScreenId: "old-screen"},
+		Screens: []*ScreenType{
+			{
+				SessionId:      "old-session",
+				ScreenId:       "old-screen",
+				ActiveWindowId: "old-window",
+				Windows: []*ScreenWindowType{
+					{SessionId: "old-session", ScreenId: "old-screen", WindowId: "old-window"},
+				},
+			},
+		},
+		Windows: []*WindowType{
+			{SessionId: "old-session", WindowId: "old-window"},
+		},
+	}
+
+	rewriteManifestIds(manifest, "local-user")
+
+	newScreenId := manifest.Screens[0].ScreenId
+	newWindowId := manifest.Windows[0].WindowId
+	if newScreenId == "" || newScreenId == "old-screen" {
+		t.Errorf("screen id not minted fresh: got %q", newScreenId)
+	}
+	if newWindowId == "" || newWindowId == "old-window" {
+		t.Errorf("window id not minted fresh: got %q", newWindowId)
+	}
+	if manifest.Session.ActiveScreenId != newScreenId {
+		t.Errorf("session activescreenid = %q, want remapped %q", manifest.Session.ActiveScreenId, newScreenId)
+	}
+	if manifest.Screens[0].ActiveWindowId != newWindowId {
+		t.Errorf("screen activewindowid = %q, want remapped %q", manifest.Screens[0].ActiveWindowId, newWindowId)
+	}
+	screenWindow := manifest.Screens[0].Windows[0]
+	if screenWindow.SessionId != manifest.Session.SessionId {
+		t.Errorf("screen_window sessionid = %q, want %q", screenWindow.SessionId, manifest.Session.SessionId)
+	}
+	if screenWindow.ScreenId != newScreenId {
+		t.Errorf("screen_window screenid = %q, want remapped %q", screenWindow.ScreenId, newScreenId)
+	}
+	if screenWindow.WindowId != newWindowId {
+		t.Errorf("screen_window windowid = %q, want remapped %q", screenWindow.WindowId, newWindowId)
+	}
+}
+
+func buildTestTar(entries map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, contents := range entries {
+		tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(contents)), Mode: 0600})
+		tw.Write(contents)
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestReadTarFilesRejectsOversizedEntry(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "huge.bin", Size: maxImportEntrySize + 1, Mode: 0600})
+	tw.Close()
+
+	if _, err := readTarFiles(&buf); err == nil {
+		t.Fatal("expected error for oversized tar entry, got nil")
+	}
+}
+
+func TestReadTarFilesRejectsTooManyEntries(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < maxImportEntries+1; i++ {
+		tw.WriteHeader(&tar.Header{Name: "file", Mode: 0600})
+	}
+	tw.Close()
+
+	if _, err := readTarFiles(&buf); err == nil {
+		t.Fatal("expected error for too many tar entries, got nil")
+	}
+}
+
+func TestReadTarFilesAcceptsWellFormedArchive(t *testing.T) {
+	raw := buildTestTar(map[string][]byte{
+		exportManifestFile: []byte(`{"session":{}}`),
+		exportSigFile:      []byte("sig"),
+		exportPubKeyFile:   []byte("pubkey"),
+	})
+	files, err := readTarFiles(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(files[exportSigFile]) != "sig" {
+		t.Errorf("manifest.sig contents = %q, want %q", files[exportSigFile], "sig")
+	}
+}
@@ -0,0 +1,46 @@
+package sstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// InsertHistoryItem persists a new history row and indexes it for full-text
+// search (see indexHistoryItem).  lineText and stdout are the associated
+// line's comment/text and the command's decoded output, passed in unsealed
+// since the caller already has them in memory -- history.cmdstr itself is
+// stored sealed, same as cmd.cmdstr.
+func InsertHistoryItem(ctx context.Context, item *HistoryItemType, lineText string, stdout string) error {
+	sealedCmdStr, err := SealStr(item.CmdStr)
+	if err != nil {
+		return fmt.Errorf("sealing history cmdstr: %w", err)
+	}
+	txErr := WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		query := `INSERT INTO history (historyid, ts, userid, sessionid, screenid, windowid, lineid, haderror, cmdid, cmdstr)
+		          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		tx.ExecWrap(query, item.HistoryId, item.Ts, item.UserId, item.SessionId, item.ScreenId, item.WindowId, item.LineId, item.HadError, item.CmdId, sealedCmdStr)
+		return nil
+	})
+	if txErr != nil {
+		return fmt.Errorf("inserting history item: %w", txErr)
+	}
+	if err := indexHistoryItem(ctx, item.HistoryId, item.CmdStr, lineText, stdout); err != nil {
+		return fmt.Errorf("indexing history item: %w", err)
+	}
+	return nil
+}
+
+// RemoveHistoryItem deletes a history row along with its search index entry.
+func RemoveHistoryItem(ctx context.Context, historyId string) error {
+	txErr := WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		tx.ExecWrap("DELETE FROM history WHERE historyid = ?", historyId)
+		return nil
+	})
+	if txErr != nil {
+		return fmt.Errorf("removing history item: %w", txErr)
+	}
+	if err := removeHistoryIndex(ctx, historyId); err != nil {
+		return fmt.Errorf("removing history index: %w", err)
+	}
+	return nil
+}
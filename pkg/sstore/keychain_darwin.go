@@ -0,0 +1,60 @@
+//go:build darwin
+
+package sstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+)
+
+const keychainService = "sh2-server"
+const keychainAccount = "master-key"
+
+// getMasterKey fetches (or creates, on first launch) a 32-byte master key
+// from the macOS Keychain via the `security` CLI.
+func getMasterKey() ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w").Output()
+	if err == nil {
+		return decodeKeychainKey(out)
+	}
+	if !isKeychainItemNotFound(err) {
+		return nil, fmt.Errorf("looking up master key in keychain: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating master key: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	addCmd := exec.Command("security", "add-generic-password", "-s", keychainService, "-a", keychainAccount, "-w", encoded, "-U")
+	if out, err := addCmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("storing master key in keychain: %w (%s)", err, bytes.TrimSpace(out))
+	}
+	return key, nil
+}
+
+// isKeychainItemNotFound reports whether err is the `security` CLI's
+// errSecItemNotFound result (exit status 44) -- the only case where minting
+// a fresh master key is correct.  Anything else (locked keychain, no GUI
+// session, etc, the normal state for sh2-server running headless) is an
+// environmental failure; treating it as "not found" would silently
+// overwrite the real key via add-generic-password -U and orphan every
+// already-sealed row.
+func isKeychainItemNotFound(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	return exitErr.ExitCode() == 44
+}
+
+func decodeKeychainKey(out []byte) ([]byte, error) {
+	encoded := string(bytes.TrimSpace(out))
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding keychain master key: %w", err)
+	}
+	return key, nil
+}
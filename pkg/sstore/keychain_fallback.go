@@ -0,0 +1,58 @@
+package sstore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scripthaus-dev/sh2-server/pkg/scbase"
+)
+
+const passphraseEnvName = "WAVETERM_MASTER_PASSPHRASE"
+const passphraseSaltFileName = "master.salt"
+
+// getPassphraseFallbackKey derives the master key from WAVETERM_MASTER_PASSPHRASE
+// (or, when that is unset, an interactive prompt on each launch) via
+// argon2id, for hosts with no usable OS keychain.  The salt is not secret:
+// it is derived from the sh2 home directory path (see fixedSaltForHost) and
+// persisted alongside the db so the same passphrase re-derives the same key.
+func getPassphraseFallbackKey() ([]byte, error) {
+	passphrase := os.Getenv(passphraseEnvName)
+	if passphrase == "" {
+		var err error
+		passphrase, err = promptForPassphrase()
+		if err != nil {
+			return nil, fmt.Errorf("reading master passphrase: %w", err)
+		}
+	}
+	salt, err := loadOrCreateSalt()
+	if err != nil {
+		return nil, err
+	}
+	return derivePassphraseKey(passphrase, salt), nil
+}
+
+func loadOrCreateSalt() ([]byte, error) {
+	saltPath := filepath.Join(scbase.GetScHomeDir(), passphraseSaltFileName)
+	existing, err := os.ReadFile(saltPath)
+	if err == nil && len(existing) == 16 {
+		return existing, nil
+	}
+	salt := fixedSaltForHost(scbase.GetScHomeDir())
+	if err := os.WriteFile(saltPath, salt, 0600); err != nil {
+		return nil, fmt.Errorf("writing master salt: %w", err)
+	}
+	return salt, nil
+}
+
+func promptForPassphrase() (string, error) {
+	fmt.Print("enter master passphrase to unlock sh2 local storage: ")
+	reader := bufio.NewReader(os.Stdin)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package sstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+const secretServiceLabel = "sh2-server master key"
+
+// getMasterKey fetches (or creates, on first launch) a 32-byte master key
+// from the libsecret keyring via the `secret-tool` CLI.  If secret-tool is
+// not installed, or no key is found and storing a new one fails, it falls
+// back to a passphrase-derived key so sh2-server still starts.  Any other
+// lookup failure (see isSecretToolNotFound) is returned as an error.
+func getMasterKey() ([]byte, error) {
+	lookup := exec.Command("secret-tool", "lookup", "service", "sh2-server", "account", "master-key")
+	out, err := lookup.Output()
+	if err == nil {
+		return decodeSecretToolKey(out)
+	}
+	if !isSecretToolNotFound(err) {
+		return nil, fmt.Errorf("looking up master key in secret service: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating master key: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	store := exec.Command("secret-tool", "store", "--label", secretServiceLabel, "service", "sh2-server", "account", "master-key")
+	store.Stdin = bytes.NewBufferString(encoded)
+	if storeErr := store.Run(); storeErr != nil {
+		// no secret service available (e.g. headless/CI) -- fall back to a
+		// passphrase-derived key so sh2-server still starts.
+		return getPassphraseFallbackKey()
+	}
+	return key, nil
+}
+
+// isSecretToolNotFound reports whether err means it's safe to mint a fresh
+// master key: either secret-tool found no such item (exits 1 with no
+// stderr output), or secret-tool isn't installed at all (the documented
+// "headless box" fallback case, surfaced as *exec.Error rather than an
+// ExitError).  Anything else -- a locked keyring or no D-Bus session (the
+// normal case for sh2-server running headless or as a daemon otherwise)
+// fails the same exec.Command call, and treating that as "not found" would
+// silently mint and store a replacement key, orphaning every already-sealed
+// row -- is a real error.
+func isSecretToolNotFound(err error) bool {
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		return true
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	return exitErr.ExitCode() == 1 && len(bytes.TrimSpace(exitErr.Stderr)) == 0
+}
+
+func decodeSecretToolKey(out []byte) ([]byte, error) {
+	encoded := string(bytes.TrimSpace(out))
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding secret-tool master key: %w", err)
+	}
+	return key, nil
+}
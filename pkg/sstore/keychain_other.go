@@ -0,0 +1,9 @@
+//go:build !darwin && !linux && !windows
+
+package sstore
+
+// getMasterKey has no OS keychain integration on this platform, so it always
+// falls back to a passphrase-derived key.
+func getMasterKey() ([]byte, error) {
+	return getPassphraseFallbackKey()
+}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package sstore
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/scripthaus-dev/sh2-server/pkg/scbase"
+	"golang.org/x/sys/windows"
+)
+
+const dpapiKeyFileName = "master.key.dpapi"
+
+// getMasterKey fetches (or creates, on first launch) a 32-byte master key
+// protected at rest with DPAPI (CryptProtectData), scoped to the current
+// Windows user.
+func getMasterKey() ([]byte, error) {
+	keyPath := filepath.Join(scbase.GetScHomeDir(), dpapiKeyFileName)
+	blob, err := os.ReadFile(keyPath)
+	if err == nil {
+		return dpapiUnprotect(blob)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating master key: %w", err)
+	}
+	protected, err := dpapiProtect(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, protected, 0600); err != nil {
+		return nil, fmt.Errorf("writing dpapi-protected master key: %w", err)
+	}
+	return key, nil
+}
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, 0, &out); err != nil {
+		return nil, fmt.Errorf("CryptProtectData: %w", err)
+	}
+	defer windows.LocalFree(windows.Handle(uintptr(unsafe.Pointer(out.Data))))
+	return dataBlobBytes(&out), nil
+}
+
+func dpapiUnprotect(blob []byte) ([]byte, error) {
+	var out windows.DataBlob
+	in := windows.DataBlob{Size: uint32(len(blob)), Data: &blob[0]}
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, 0, &out); err != nil {
+		return nil, fmt.Errorf("CryptUnprotectData: %w", err)
+	}
+	defer windows.LocalFree(windows.Handle(uintptr(unsafe.Pointer(out.Data))))
+	return dataBlobBytes(&out), nil
+}
+
+// dataBlobBytes copies a DPAPI-allocated DataBlob's contents into a Go byte
+// slice that outlives the LocalFree'd buffer.
+func dataBlobBytes(blob *windows.DataBlob) []byte {
+	src := unsafe.Slice(blob.Data, blob.Size)
+	rtn := make([]byte, len(src))
+	copy(rtn, src)
+	return rtn
+}
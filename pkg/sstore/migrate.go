@@ -0,0 +1,171 @@
+package sstore
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrateDB applies any outstanding schema migrations to db for the given
+// dialect.  For sqlite3 this is a no-op -- the per-user file's schema is
+// created out of band (see the sql/ migration assets) before sh2-server ever
+// opens it.  mysql has no such out-of-band step (a fresh server-side
+// database starts completely empty), so Backend.Migrate must create the
+// schema itself the first time it connects.
+func MigrateDB(db *sqlx.DB, dialect string) error {
+	if dialect == "sqlite3" {
+		return nil
+	}
+	for _, stmt := range coreSchemaStatements(dialect) {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("running schema migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// coreSchemaStatements returns the CREATE TABLE statements for the tables
+// sstore.go/export.go read and write, using dialect-appropriate column
+// types.  Statements are idempotent (IF NOT EXISTS) so re-running them
+// against an already-migrated database is harmless.
+func coreSchemaStatements(dialect string) []string {
+	t := schemaTypesForDialect(dialect)
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS client (
+			userid %s PRIMARY KEY,
+			userpublickeybytes %s,
+			userprivatekeybytes %s
+		)`, t.id, t.blob, t.blob),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS session (
+			sessionid %s PRIMARY KEY,
+			name %s,
+			sessionidx %s,
+			activescreenid %s,
+			owneruserid %s,
+			sharemode %s,
+			accesskey %s,
+			notifynum %s
+		)`, t.id, t.text, t.bigint, t.id, t.id, t.text, t.text, t.bigint),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS screen (
+			sessionid %s,
+			screenid %s,
+			screenidx %s,
+			name %s,
+			activewindowid %s,
+			screenopts %s,
+			owneruserid %s,
+			sharemode %s,
+			PRIMARY KEY (sessionid, screenid)
+		)`, t.id, t.id, t.bigint, t.text, t.id, t.text, t.id, t.text),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS screen_window (
+			sessionid %s,
+			screenid %s,
+			windowid %s,
+			name %s,
+			layout %s,
+			PRIMARY KEY (sessionid, screenid, windowid)
+		)`, t.id, t.id, t.id, t.text, t.text),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS window (
+			sessionid %s,
+			windowid %s,
+			curremote %s,
+			winopts %s,
+			owneruserid %s,
+			sharemode %s,
+			shareopts %s,
+			PRIMARY KEY (sessionid, windowid)
+		)`, t.id, t.id, t.id, t.text, t.id, t.text, t.text),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS line (
+			sessionid %s,
+			windowid %s,
+			lineid %s,
+			ts %s,
+			userid %s,
+			linetype %s,
+			text %s,
+			cmdid %s,
+			PRIMARY KEY (sessionid, windowid, lineid)
+		)`, t.id, t.id, t.id, t.bigint, t.id, t.text, t.text, t.id),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS cmd (
+			sessionid %s,
+			cmdid %s,
+			remoteid %s,
+			cmdstr %s,
+			remotestate %s,
+			termopts %s,
+			status %s,
+			startpk %s,
+			donepk %s,
+			runout %s,
+			usedrows %s,
+			PRIMARY KEY (sessionid, cmdid)
+		)`, t.id, t.id, t.id, t.text, t.text, t.text, t.text, t.text, t.text, t.text, t.bigint),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS remote (
+			remoteid %s PRIMARY KEY,
+			physicalid %s,
+			remotetype %s,
+			remotealias %s,
+			remotecanonicalname %s,
+			remotesudo %s,
+			remoteuser %s,
+			remotehost %s,
+			autoconnect %s,
+			initpk %s,
+			sshopts %s,
+			lastconnectts %s
+		)`, t.id, t.text, t.text, t.text, t.text, t.boolean, t.text, t.text, t.boolean, t.text, t.text, t.bigint),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS remote_instance (
+			riid %s PRIMARY KEY,
+			name %s,
+			sessionid %s,
+			windowid %s,
+			remoteid %s,
+			sessionscope %s,
+			state %s
+		)`, t.id, t.text, t.id, t.id, t.id, t.boolean, t.text),
+
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS history (
+			historyid %s PRIMARY KEY,
+			ts %s,
+			userid %s,
+			sessionid %s,
+			screenid %s,
+			windowid %s,
+			lineid %s,
+			haderror %s,
+			cmdid %s,
+			cmdstr %s
+		)`, t.id, t.bigint, t.id, t.id, t.id, t.id, t.id, t.boolean, t.id, t.text),
+	}
+}
+
+// schemaColumnTypes holds the column types coreSchemaStatements fills its
+// templates with, one set per dialect.
+type schemaColumnTypes struct {
+	id      string
+	text    string
+	bigint  string
+	boolean string
+	blob    string
+}
+
+func schemaTypesForDialect(dialect string) schemaColumnTypes {
+	// mysql can't key a bare TEXT/BLOB column without an explicit prefix
+	// length, so ids use VARCHAR instead.  mysql is the only dialect that
+	// reaches this function -- sqlite3 short-circuits in MigrateDB.
+	return schemaColumnTypes{
+		id:      "VARCHAR(64)",
+		text:    "TEXT",
+		bigint:  "BIGINT",
+		boolean: "TINYINT(1)",
+		blob:    "BLOB",
+	}
+}
@@ -0,0 +1,141 @@
+package sstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// SealedPrefix marks a value as an AES-GCM envelope produced by Sealer, so
+// unseal can tell sealed data apart from plaintext left over from before
+// encryption-at-rest was introduced (handled by MigrateSealedData).
+const SealedPrefix = "sealed:v1:"
+
+// Sealer seals/unseals bytes with envelope encryption, so that plaintext
+// like the user's private key or recorded command output never hits disk.
+type Sealer interface {
+	Seal(plaintext []byte) (string, error)
+	Unseal(sealed string) ([]byte, error)
+}
+
+// AESGCMSealer is the default Sealer, keyed by a 32-byte master key sourced
+// from the OS keychain (or an argon2id-derived passphrase key as a
+// fallback -- see getMasterKey).
+type AESGCMSealer struct {
+	masterKey []byte
+}
+
+var globalSealerOnce sync.Once
+var globalSealer *AESGCMSealer
+var globalSealerErr error
+
+// GetSealer returns the process-wide Sealer, deriving the master key on
+// first use.
+func GetSealer() (*AESGCMSealer, error) {
+	globalSealerOnce.Do(func() {
+		key, err := getMasterKey()
+		if err != nil {
+			globalSealerErr = fmt.Errorf("deriving master key: %w", err)
+			return
+		}
+		globalSealer = &AESGCMSealer{masterKey: key}
+	})
+	return globalSealer, globalSealerErr
+}
+
+func (s *AESGCMSealer) Seal(plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(s.masterKey)
+	if err != nil {
+		return "", fmt.Errorf("creating aes cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("creating gcm: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	return SealedPrefix + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func (s *AESGCMSealer) Unseal(sealed string) ([]byte, error) {
+	if !IsSealed(sealed) {
+		// pre-encryption-at-rest plaintext row; caller reseals via MigrateSealedData.
+		return []byte(sealed), nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed[len(SealedPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("decoding sealed value: %w", err)
+	}
+	block, err := aes.NewCipher(s.masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating aes cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating gcm: %w", err)
+	}
+	nonceSize := gcm.NonceSize()
+	if len(raw) < nonceSize {
+		return nil, fmt.Errorf("sealed value too short")
+	}
+	nonce, ciphertext := raw[:nonceSize], raw[nonceSize:]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unsealing value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// IsSealed reports whether val is an AES-GCM envelope produced by Sealer
+// (as opposed to plaintext left over from before encryption-at-rest).
+func IsSealed(val string) bool {
+	return len(val) >= len(SealedPrefix) && val[:len(SealedPrefix)] == SealedPrefix
+}
+
+// SealStr is a convenience wrapper for sealing a string field with the
+// process-wide Sealer.
+func SealStr(plaintext string) (string, error) {
+	sealer, err := GetSealer()
+	if err != nil {
+		return "", err
+	}
+	return sealer.Seal([]byte(plaintext))
+}
+
+// UnsealStr is a convenience wrapper for unsealing a string field with the
+// process-wide Sealer.
+func UnsealStr(sealed string) (string, error) {
+	sealer, err := GetSealer()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := sealer.Unseal(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// derivePassphraseKey derives a 32-byte key from a passphrase using
+// argon2id, for hosts where no OS keychain is available.
+func derivePassphraseKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32)
+}
+
+// fixedSaltForHost derives a stable, non-secret salt from the host's
+// sh2-server home directory path, so repeated runs against the same
+// ~/.sh2 re-derive the same passphrase-fallback key.
+func fixedSaltForHost(scHome string) []byte {
+	sum := sha256.Sum256([]byte("sh2-sealer-salt:" + scHome))
+	return sum[:16]
+}
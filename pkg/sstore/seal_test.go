@@ -0,0 +1,48 @@
+package sstore
+
+import "testing"
+
+func testSealer(t *testing.T) *AESGCMSealer {
+	t.Helper()
+	return &AESGCMSealer{masterKey: make([]byte, 32)}
+}
+
+func TestSealUnsealRoundTrip(t *testing.T) {
+	sealer := testSealer(t)
+	plaintext := "ls -la /home/user"
+	sealed, err := sealer.Seal([]byte(plaintext))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !IsSealed(sealed) {
+		t.Fatalf("sealed value %q not recognized as sealed", sealed)
+	}
+	unsealed, err := sealer.Unseal(sealed)
+	if err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if string(unsealed) != plaintext {
+		t.Errorf("unsealed = %q, want %q", unsealed, plaintext)
+	}
+}
+
+func TestUnsealPassesThroughLegacyPlaintext(t *testing.T) {
+	sealer := testSealer(t)
+	legacy := "echo hello"
+	unsealed, err := sealer.Unseal(legacy)
+	if err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if string(unsealed) != legacy {
+		t.Errorf("unsealed = %q, want passthrough %q", unsealed, legacy)
+	}
+}
+
+func TestIsSealed(t *testing.T) {
+	if IsSealed("plain command") {
+		t.Error("plain value reported as sealed")
+	}
+	if !IsSealed(SealedPrefix + "abc123") {
+		t.Error("prefixed value not reported as sealed")
+	}
+}
@@ -0,0 +1,124 @@
+package sstore
+
+import (
+	"context"
+	"fmt"
+)
+
+// MigrateSealedData rewrites any plaintext client/cmd/history rows left over
+// from before encryption-at-rest was introduced, sealing them in place so
+// that a single upgrade is enough -- callers don't need to special-case old
+// rows on every read (Unseal already tolerates plaintext via IsSealed).  It
+// runs from GetDB right after schema migrations, and is re-run after a
+// failure until it has succeeded once in this process (see
+// ensureSealMigration).
+func MigrateSealedData(ctx context.Context) error {
+	if err := migrateSealedClientKey(ctx); err != nil {
+		return fmt.Errorf("migrating client table: %w", err)
+	}
+	if err := migrateSealedCmds(ctx); err != nil {
+		return fmt.Errorf("migrating cmd table: %w", err)
+	}
+	if err := migrateSealedHistory(ctx); err != nil {
+		return fmt.Errorf("migrating history table: %w", err)
+	}
+	return nil
+}
+
+func migrateSealedClientKey(ctx context.Context) error {
+	return WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		var userId string
+		if found := tx.GetWrap(&userId, "SELECT userid FROM client"); !found {
+			return nil
+		}
+		var pkBytes []byte
+		tx.GetWrap(&pkBytes, "SELECT userprivatekeybytes FROM client WHERE userid = ?", userId)
+		if IsSealed(string(pkBytes)) {
+			return nil
+		}
+		sealedPk, err := SealStr(string(pkBytes))
+		if err != nil {
+			return err
+		}
+		tx.ExecWrap("UPDATE client SET userprivatekeybytes = ? WHERE userid = ?", []byte(sealedPk), userId)
+		return nil
+	})
+}
+
+// sealMigrateBatchSize bounds how many cmd/history rows a single migration
+// transaction reseals.  A pre-upgrade backlog large enough to take longer
+// than DefaultDBTimeout to reseal in one shot would otherwise fail the
+// whole migration with ErrTimeout; chunking it across many bounded
+// transactions lets each batch finish comfortably and lets a later batch
+// pick up where the previous one left off.
+const sealMigrateBatchSize = 200
+
+func migrateSealedCmds(ctx context.Context) error {
+	for {
+		n, err := migrateSealedCmdsBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+func migrateSealedCmdsBatch(ctx context.Context) (int, error) {
+	var numSealed int
+	err := WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		var cmdIds []string
+		tx.SelectWrap(&cmdIds, "SELECT cmdid FROM cmd WHERE cmdstr NOT LIKE ? LIMIT ?", SealedPrefix+"%", sealMigrateBatchSize)
+		for _, cmdId := range cmdIds {
+			var cmdStr string
+			var runOut string
+			tx.GetWrap(&cmdStr, "SELECT cmdstr FROM cmd WHERE cmdid = ?", cmdId)
+			tx.GetWrap(&runOut, "SELECT runout FROM cmd WHERE cmdid = ?", cmdId)
+			sealedCmdStr, err := SealStr(cmdStr)
+			if err != nil {
+				return err
+			}
+			sealedRunOut, err := SealStr(runOut)
+			if err != nil {
+				return err
+			}
+			tx.ExecWrap("UPDATE cmd SET cmdstr = ?, runout = ? WHERE cmdid = ?", sealedCmdStr, sealedRunOut, cmdId)
+		}
+		numSealed = len(cmdIds)
+		return nil
+	})
+	return numSealed, err
+}
+
+func migrateSealedHistory(ctx context.Context) error {
+	for {
+		n, err := migrateSealedHistoryBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+func migrateSealedHistoryBatch(ctx context.Context) (int, error) {
+	var numSealed int
+	err := WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		var historyIds []string
+		tx.SelectWrap(&historyIds, "SELECT historyid FROM history WHERE cmdstr NOT LIKE ? LIMIT ?", SealedPrefix+"%", sealMigrateBatchSize)
+		for _, historyId := range historyIds {
+			var cmdStr string
+			tx.GetWrap(&cmdStr, "SELECT cmdstr FROM history WHERE historyid = ?", historyId)
+			sealedCmdStr, err := SealStr(cmdStr)
+			if err != nil {
+				return err
+			}
+			tx.ExecWrap("UPDATE history SET cmdstr = ? WHERE historyid = ?", sealedCmdStr, historyId)
+		}
+		numSealed = len(historyIds)
+		return nil
+	})
+	return numSealed, err
+}
@@ -0,0 +1,118 @@
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// historyFtsSchema creates the history_fts FTS5 index; it defines no
+// triggers.  Content columns are indexed in
+// plaintext even though history.cmdstr is sealed at rest (see
+// [[panoskyriakis/waveterm#chunk0-3]]) -- history_fts is populated from
+// application code (indexHistoryItem) rather than SQL triggers on the
+// history/line/cmd tables, since a trigger only ever sees ciphertext.
+const historyFtsSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS history_fts USING fts5(
+    historyid UNINDEXED,
+    cmdstr,
+    linetext,
+    stdout,
+    tokenize = 'porter unicode61'
+);
+`
+
+// SearchFilters narrows a SearchHistory query beyond the free-text match.
+type SearchFilters struct {
+	SessionId string
+	RemoteId  string
+	FromTs    int64
+	ToTs      int64
+	HadError  *bool
+}
+
+// SearchResult is a ranked HistoryItemType match with a highlighted snippet
+// of the matching text.
+type SearchResult struct {
+	HistoryItemType
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// indexHistoryItem (re)indexes a single history row's searchable text.
+// Called after a history item is inserted/updated with its *unsealed*
+// cmdstr, associated comment/line text, and decoded stdout.
+func indexHistoryItem(ctx context.Context, historyId string, cmdStr string, lineText string, stdout string) error {
+	if backend, err := GetBackend(); err == nil && backend.Dialect() != "sqlite3" {
+		return nil
+	}
+	return WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		tx.ExecWrap("DELETE FROM history_fts WHERE historyid = ?", historyId)
+		tx.ExecWrap("INSERT INTO history_fts (historyid, cmdstr, linetext, stdout) VALUES (?, ?, ?, ?)", historyId, cmdStr, lineText, stdout)
+		return nil
+	})
+}
+
+// removeHistoryIndex drops a history item's FTS row, e.g. when the history
+// item itself is deleted.
+func removeHistoryIndex(ctx context.Context, historyId string) error {
+	if backend, err := GetBackend(); err == nil && backend.Dialect() != "sqlite3" {
+		return nil
+	}
+	return WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		tx.ExecWrap("DELETE FROM history_fts WHERE historyid = ?", historyId)
+		return nil
+	})
+}
+
+// SearchHistory runs a full-text query against history_fts and returns
+// ranked HistoryItemType results (most relevant first) with highlighted
+// snippets, narrowed by filters.
+func SearchHistory(ctx context.Context, query string, filters SearchFilters) ([]*SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	sqlQuery := `
+SELECT h.*, snippet(history_fts, -1, '[', ']', '...', 16) as snippet, bm25(history_fts) as rank
+FROM history_fts
+JOIN history h ON h.historyid = history_fts.historyid
+WHERE history_fts MATCH ?
+`
+	args := []interface{}{query}
+	if filters.SessionId != "" {
+		sqlQuery += " AND h.sessionid = ?"
+		args = append(args, filters.SessionId)
+	}
+	if filters.RemoteId != "" {
+		sqlQuery += " AND EXISTS (SELECT 1 FROM cmd c WHERE c.sessionid = h.sessionid AND c.cmdid = h.cmdid AND c.remoteid = ?)"
+		args = append(args, filters.RemoteId)
+	}
+	if filters.FromTs > 0 {
+		sqlQuery += " AND h.ts >= ?"
+		args = append(args, filters.FromTs)
+	}
+	if filters.ToTs > 0 {
+		sqlQuery += " AND h.ts <= ?"
+		args = append(args, filters.ToTs)
+	}
+	if filters.HadError != nil {
+		sqlQuery += " AND h.haderror = ?"
+		args = append(args, *filters.HadError)
+	}
+	sqlQuery += " ORDER BY rank LIMIT 200"
+	var rtn []*SearchResult
+	txErr := WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
+		tx.SelectWrap(&rtn, sqlQuery, args...)
+		return nil
+	})
+	if txErr != nil {
+		return nil, fmt.Errorf("searching history: %w", txErr)
+	}
+	for _, result := range rtn {
+		if unsealed, err := UnsealStr(result.CmdStr); err == nil {
+			result.CmdStr = unsealed
+		}
+	}
+	return rtn, nil
+}
@@ -7,12 +7,14 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/user"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,8 +22,6 @@ import (
 	"github.com/scripthaus-dev/mshell/pkg/base"
 	"github.com/scripthaus-dev/mshell/pkg/packet"
 	"github.com/scripthaus-dev/sh2-server/pkg/scbase"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const LineTypeCmd = "cmd"
@@ -53,23 +53,103 @@ const (
 var globalDBLock = &sync.Mutex{}
 var globalDB *sqlx.DB
 var globalDBErr error
+var globalBackend Backend
 
 func GetSessionDBName() string {
 	scHome := scbase.GetScHomeDir()
 	return path.Join(scHome, DBFileName)
 }
 
+// GetBackend returns the active storage Backend, opening it (from
+// WAVETERM_DB, or the default per-user sqlite3 file if unset) on first call.
+func GetBackend() (Backend, error) {
+	globalDBLock.Lock()
+	defer globalDBLock.Unlock()
+	if globalBackend == nil {
+		backend, err := BackendFromUri(os.Getenv(DBUriEnvName), GetSessionDBName())
+		if err != nil {
+			return nil, err
+		}
+		globalBackend = backend
+	}
+	return globalBackend, nil
+}
+
+var globalSealMigrateLock sync.Mutex
+var globalSealMigrateDone int32 // atomic; 1 once MigrateSealedData has succeeded
+var globalSealMigrateNextAttempt time.Time
+
+// sealMigrateRetryInterval keeps a persistently-failing migration (a
+// backlog too big to finish inside DefaultDBTimeout, or a row SealStr can
+// never encode) from re-running on every single GetDB call.
+const sealMigrateRetryInterval = 30 * time.Second
+
+// sealMigrateContextKey marks a context as already running inside
+// ensureSealMigration, so a nested GetDB call made while MigrateSealedData
+// is still in progress (it goes through WithTxDeadline, which calls back
+// into GetDB to grab a connection) skips re-entering ensureSealMigration
+// instead of deadlocking on globalSealMigrateLock.
+type sealMigrateContextKeyType struct{}
+
+var sealMigrateContextKey = sealMigrateContextKeyType{}
+
 func GetDB(ctx context.Context) (*sqlx.DB, error) {
 	if IsTxWrapContext(ctx) {
 		return nil, fmt.Errorf("cannot call GetDB from within a running transaction")
 	}
+	db, err := openGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Value(sealMigrateContextKey) == nil {
+		ensureSealMigration(ctx)
+	}
+	return db, nil
+}
+
+// ensureSealMigration runs the legacy-plaintext backfill once per process,
+// after schema migrations but outside globalDBLock.
+//
+// A failure (most commonly ErrTimeout, on an install with a large
+// pre-upgrade backlog) is logged and retried on the first GetDB call made
+// after sealMigrateRetryInterval has passed, rather than cached forever:
+// migrateSealedCmds/migrateSealedHistory already
+// reseal in bounded batches, so a later call picks up wherever the
+// previous one left off instead of wedging the database until restart.
+func ensureSealMigration(ctx context.Context) {
+	if atomic.LoadInt32(&globalSealMigrateDone) == 1 {
+		return
+	}
+	globalSealMigrateLock.Lock()
+	defer globalSealMigrateLock.Unlock()
+	if atomic.LoadInt32(&globalSealMigrateDone) == 1 {
+		return
+	}
+	if time.Now().Before(globalSealMigrateNextAttempt) {
+		return
+	}
+	migrateCtx := context.WithValue(ctx, sealMigrateContextKey, true)
+	if err := MigrateSealedData(migrateCtx); err != nil {
+		log.Printf("sstore: sealed-data migration did not finish, will retry: %v", err)
+		globalSealMigrateNextAttempt = time.Now().Add(sealMigrateRetryInterval)
+		return
+	}
+	atomic.StoreInt32(&globalSealMigrateDone, 1)
+}
+
+func openGlobalDB() (*sqlx.DB, error) {
 	globalDBLock.Lock()
 	defer globalDBLock.Unlock()
 	if globalDB == nil && globalDBErr == nil {
-		dbName := GetSessionDBName()
-		globalDB, globalDBErr = sqlx.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared&mode=rwc&_journal_mode=WAL&_busy_timeout=5000", dbName))
-		if globalDBErr != nil {
-			globalDBErr = fmt.Errorf("opening db[%s]: %w", dbName, globalDBErr)
+		backend, err := BackendFromUri(os.Getenv(DBUriEnvName), GetSessionDBName())
+		if err != nil {
+			globalDBErr = err
+			return globalDB, globalDBErr
+		}
+		globalBackend = backend
+		globalDB, globalDBErr = backend.Open()
+		if globalDBErr == nil {
+			globalDBErr = backend.Migrate(globalDB)
 		}
 	}
 	return globalDB, globalDBErr
@@ -355,17 +435,26 @@ func RemoteFromMap(m map[string]interface{}) *RemoteType {
 }
 
 func (cmd *CmdType) ToMap() map[string]interface{} {
+	sealedCmdStr, err := SealStr(cmd.CmdStr)
+	if err != nil {
+		sealedCmdStr = cmd.CmdStr
+	}
+	runOutJson, _ := json.Marshal(cmd.RunOut)
+	sealedRunOut, err := SealStr(string(runOutJson))
+	if err != nil {
+		sealedRunOut = string(runOutJson)
+	}
 	rtn := make(map[string]interface{})
 	rtn["sessionid"] = cmd.SessionId
 	rtn["cmdid"] = cmd.CmdId
 	rtn["remoteid"] = cmd.RemoteId
-	rtn["cmdstr"] = cmd.CmdStr
+	rtn["cmdstr"] = sealedCmdStr
 	rtn["remotestate"] = quickJson(cmd.RemoteState)
 	rtn["termopts"] = quickJson(cmd.TermOpts)
 	rtn["status"] = cmd.Status
 	rtn["startpk"] = quickJson(cmd.StartPk)
 	rtn["donepk"] = quickJson(cmd.DonePk)
-	rtn["runout"] = quickJson(cmd.RunOut)
+	rtn["runout"] = sealedRunOut
 	rtn["usedrows"] = cmd.UsedRows
 	return rtn
 }
@@ -379,12 +468,19 @@ func CmdFromMap(m map[string]interface{}) *CmdType {
 	quickSetStr(&cmd.CmdId, m, "cmdid")
 	quickSetStr(&cmd.RemoteId, m, "remoteid")
 	quickSetStr(&cmd.CmdStr, m, "cmdstr")
+	if unsealed, err := UnsealStr(cmd.CmdStr); err == nil {
+		cmd.CmdStr = unsealed
+	}
 	quickSetJson(&cmd.RemoteState, m, "remotestate")
 	quickSetJson(&cmd.TermOpts, m, "termopts")
 	quickSetStr(&cmd.Status, m, "status")
 	quickSetJson(&cmd.StartPk, m, "startpk")
 	quickSetJson(&cmd.DonePk, m, "donepk")
-	quickSetJson(&cmd.RunOut, m, "runout")
+	var sealedRunOut string
+	quickSetStr(&sealedRunOut, m, "runout")
+	if unsealed, err := UnsealStr(sealedRunOut); err == nil {
+		json.Unmarshal([]byte(unsealed), &cmd.RunOut)
+	}
 	quickSetInt64(&cmd.UsedRows, m, "usedrows")
 	return &cmd
 }
@@ -419,6 +515,9 @@ func AddCommentLine(ctx context.Context, sessionId string, windowId string, user
 	if err != nil {
 		return nil, err
 	}
+	if err := PublishUpdate(ctx, ModelUpdate{Lines: []*LineType{rtnLine}}); err != nil {
+		log.Printf("[db] error publishing update: %v\n", err)
+	}
 	return rtnLine, nil
 }
 
@@ -428,6 +527,9 @@ func AddCmdLine(ctx context.Context, sessionId string, windowId string, userId s
 	if err != nil {
 		return nil, err
 	}
+	if err := PublishUpdate(ctx, ModelUpdate{Lines: []*LineType{rtnLine}, Cmds: []*CmdType{cmd}}); err != nil {
+		log.Printf("[db] error publishing update: %v\n", err)
+	}
 	return rtnLine, nil
 }
 
@@ -500,15 +602,19 @@ func createUserData(tx *TxWrap) error {
 	if err != nil {
 		return fmt.Errorf("marshaling (pkix) public key bytes: %w", err)
 	}
+	sealedPkBytes, err := SealStr(string(pkBytes))
+	if err != nil {
+		return fmt.Errorf("sealing private key: %w", err)
+	}
 	query := `INSERT INTO client (userid, userpublickeybytes, userprivatekeybytes) VALUES (?, ?, ?)`
-	tx.ExecWrap(query, userId, pubBytes, pkBytes)
+	tx.ExecWrap(query, userId, pubBytes, []byte(sealedPkBytes))
 	fmt.Printf("create new userid[%s] with public/private keypair\n", userId)
 	return nil
 }
 
 func EnsureUserData(ctx context.Context) (*UserData, error) {
 	var rtn UserData
-	err := WithTx(ctx, func(tx *TxWrap) error {
+	err := WithTxDeadline(ctx, DefaultDBTimeout, func(tx *TxWrap) error {
 		query := `SELECT count(*) FROM client`
 		count := tx.GetInt(query)
 		if count > 1 {
@@ -535,6 +641,11 @@ func EnsureUserData(ctx context.Context) (*UserData, error) {
 	if len(rtn.UserPrivateKeyBytes) == 0 || len(rtn.UserPublicKeyBytes) == 0 {
 		return nil, fmt.Errorf("invalid client data (no public/private keypair)")
 	}
+	unsealedPkBytes, err := UnsealStr(string(rtn.UserPrivateKeyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("unsealing private key: %w", err)
+	}
+	rtn.UserPrivateKeyBytes = []byte(unsealedPkBytes)
 	rtn.UserPrivateKey, err = x509.ParseECPrivateKey(rtn.UserPrivateKeyBytes)
 	if err != nil {
 		return nil, fmt.Errorf("invalid client data, cannot parse private key: %w", err)
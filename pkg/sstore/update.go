@@ -0,0 +1,83 @@
+package sstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/scripthaus-dev/sh2-server/pkg/sstore/broker"
+)
+
+// BrokerUriEnvName points sh2-server at a redis instance to use as the
+// cross-process update bus, e.g. WAVETERM_BROKER=redis://localhost:6379
+const BrokerUriEnvName = "WAVETERM_BROKER"
+
+// InitBroker configures the process-wide broker.PubSub from WAVETERM_BROKER.
+// With no env var set, updates stay local to this process (the default
+// broker.NoOpPubSub).
+func InitBroker(ctx context.Context) error {
+	addr := os.Getenv(BrokerUriEnvName)
+	if addr == "" {
+		return nil
+	}
+	ps, err := broker.NewRedisPubSub(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("initializing broker: %w", err)
+	}
+	broker.Set(ps)
+	return nil
+}
+
+// ModelUpdate is the same sparse Remove/Full-tagged payload sh2-server
+// already pushes to its own websocket clients.  PublishUpdate fans it out to
+// other sh2-server processes over the active broker.PubSub so that a server
+// horizontally scaled beyond a single node still sees consistent updates.
+type ModelUpdate struct {
+	Sessions []*SessionType `json:"sessions,omitempty"`
+	Windows  []*WindowType  `json:"windows,omitempty"`
+	Screens  []*ScreenType  `json:"screens,omitempty"`
+	Lines    []*LineType    `json:"lines,omitempty"`
+	Cmds     []*CmdType     `json:"cmds,omitempty"`
+}
+
+// PublishUpdate fans update out to other sh2-server processes.  It is a
+// no-op unless broker.Set has been called with a real transport (e.g.
+// broker.RedisPubSub), so it is safe to call after every mutating helper.
+func PublishUpdate(ctx context.Context, update ModelUpdate) error {
+	barr, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("marshaling update: %w", err)
+	}
+	if err := broker.Get().Publish(ctx, barr); err != nil {
+		return fmt.Errorf("publishing update: %w", err)
+	}
+	return nil
+}
+
+// SubscribeUpdates decodes ModelUpdates published on the active
+// broker.PubSub by any sh2-server process, this one included (its own
+// publishes are not filtered out).  Callers (e.g. the websocket
+// server) feed the results into their own connected clients.
+func SubscribeUpdates(ctx context.Context) (<-chan ModelUpdate, error) {
+	raw, err := broker.Get().Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rtn := make(chan ModelUpdate)
+	go func() {
+		defer close(rtn)
+		for barr := range raw {
+			var update ModelUpdate
+			if err := json.Unmarshal(barr, &update); err != nil {
+				continue
+			}
+			select {
+			case rtn <- update:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return rtn, nil
+}